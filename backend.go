@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"path/filepath"
+	"strings"
+)
+
+// trimETag strips the surrounding quotes S3 (and S3-compatible) APIs
+// wrap ETags in.
+func trimETag(etag string) string {
+	return strings.Trim(etag, `"`)
+}
+
+// destPrefix normalizes Config.S3.Prefix the same way uploadFile builds
+// each object's destination key (filepath.Join("/", Prefix, path)), so
+// any code listing or filtering by prefix agrees with what keys actually
+// look like on the backend.
+func destPrefix(cfg *Config) string {
+	return filepath.Join("/", cfg.S3.Prefix)
+}
+
+// PutOptions carries the per-object settings uploadFile assembles before
+// handing a file's body off to a Backend.
+type PutOptions struct {
+	ACL                string
+	ContentType        string
+	CacheControl       string
+	ContentEncoding    string
+	ContentDisposition string
+	Metadata           map[string]string
+}
+
+// Backend is the set of object-storage operations s3up needs. The AWS S3
+// SDK backend (and any S3-compatible endpoint reachable through it, such
+// as MinIO, Wasabi, DigitalOcean Spaces, or Backblaze B2) is the default;
+// a file:// backend is provided for local dry-testing without touching a
+// real bucket.
+type Backend interface {
+	// PutObject uploads body and returns the resulting object's ETag, so
+	// callers can verify it against a locally-computed hash.
+	PutObject(key string, body io.ReadSeeker, opts PutOptions) (etag string, err error)
+	HeadObject(key string) (etag string, ok bool, err error)
+	ListObjects(prefix string) ([]string, error)
+	DeleteObjects(keys []string) error
+}
+
+// newBackend picks a Backend the same way Go's well-known-filesystem
+// packages dispatch on a URL scheme: s3:// (the default, also covering
+// S3-compatible endpoints configured via Config.S3.Endpoint) or file://
+// for local testing. gs:// (Google Cloud Storage) is deliberately out of
+// scope for now - there's no GCS client in this module's dependencies -
+// and is called out by name below rather than falling through to a
+// generic "unsupported scheme" error.
+func newBackend(cfg *Config) (Backend, error) {
+	scheme := "s3"
+	if cfg.S3.Destination != "" {
+		u, err := url.Parse(cfg.S3.Destination)
+		if err != nil {
+			return nil, err
+		}
+		if u.Scheme != "" {
+			scheme = u.Scheme
+		}
+	}
+
+	switch scheme {
+	case "s3":
+		return newS3Backend(cfg)
+	case "file":
+		return newFileBackend(cfg)
+	case "gs":
+		return nil, errors.New("gs:// destinations are not implemented; only s3:// (including S3-compatible endpoints) and file:// are supported")
+	default:
+		return nil, fmt.Errorf("unsupported destination scheme %q", scheme)
+	}
+}
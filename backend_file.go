@@ -0,0 +1,102 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+)
+
+// fileBackend writes objects to a local directory instead of a bucket.
+// It exists so the rest of s3up (config parsing, glob rules, sync/delete
+// logic) can be exercised without a real S3 account, e.g. in tests or a
+// dry run against disk.
+type fileBackend struct {
+	Root string
+}
+
+func newFileBackend(cfg *Config) (*fileBackend, error) {
+	root := cfg.S3.Destination
+	if u, err := url.Parse(cfg.S3.Destination); err == nil && u.Scheme == "file" {
+		root = filepath.Join(u.Host, u.Path)
+	}
+
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, err
+	}
+	return &fileBackend{Root: root}, nil
+}
+
+func (b *fileBackend) PutObject(key string, body io.ReadSeeker, opts PutOptions) (string, error) {
+	dest := filepath.Join(b.Root, key)
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return "", err
+	}
+
+	out, err := os.Create(dest)
+	if err != nil {
+		return "", err
+	}
+	defer out.Close()
+
+	h := md5.New()
+	if _, err := io.Copy(io.MultiWriter(out, h), body); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func (b *fileBackend) HeadObject(key string) (string, bool, error) {
+	file, err := os.Open(filepath.Join(b.Root, key))
+	if os.IsNotExist(err) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	defer file.Close()
+
+	h := md5.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return "", false, err
+	}
+	return hex.EncodeToString(h.Sum(nil)), true, nil
+}
+
+func (b *fileBackend) ListObjects(prefix string) ([]string, error) {
+	var keys []string
+	root := filepath.Join(b.Root, prefix)
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(b.Root, path)
+		if err != nil {
+			return err
+		}
+		keys = append(keys, filepath.Join("/", rel))
+		return nil
+	})
+	return keys, err
+}
+
+func (b *fileBackend) DeleteObjects(keys []string) error {
+	for _, key := range keys {
+		if err := os.Remove(filepath.Join(b.Root, key)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,400 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+// s3API is the subset of the AWS S3 client s3Backend calls directly
+// (everything except a fresh multipart upload, which goes through
+// s3manager.Uploader; UploadPart/CompleteMultipartUpload are used only
+// to resume an upload that already has a tracked UploadId). Depending on
+// an interface instead of the concrete *s3.S3 lets tests substitute an
+// in-memory fake.
+type s3API interface {
+	HeadObject(*s3.HeadObjectInput) (*s3.HeadObjectOutput, error)
+	ListObjectsV2Pages(*s3.ListObjectsV2Input, func(*s3.ListObjectsV2Output, bool) bool) error
+	ListMultipartUploads(*s3.ListMultipartUploadsInput) (*s3.ListMultipartUploadsOutput, error)
+	ListParts(*s3.ListPartsInput) (*s3.ListPartsOutput, error)
+	UploadPart(*s3.UploadPartInput) (*s3.UploadPartOutput, error)
+	CompleteMultipartUpload(*s3.CompleteMultipartUploadInput) (*s3.CompleteMultipartUploadOutput, error)
+	AbortMultipartUpload(*s3.AbortMultipartUploadInput) (*s3.AbortMultipartUploadOutput, error)
+	DeleteObjects(*s3.DeleteObjectsInput) (*s3.DeleteObjectsOutput, error)
+}
+
+// resumableUpload is a multipart upload found in-progress on S3 at
+// startup that still has at least one part landed, keyed by destination
+// key. PutObject consults it to continue the same UploadId part-by-part
+// instead of starting over via s3manager.
+type resumableUpload struct {
+	UploadID string
+	Parts    []*s3.Part // parts already uploaded, from ListParts
+}
+
+// s3Backend talks to AWS S3, or any S3-compatible endpoint reachable
+// through the same API (MinIO, Wasabi, DigitalOcean Spaces, Backblaze
+// B2, ...) when Config.S3.Endpoint is set.
+type s3Backend struct {
+	Config   *Config
+	Conn     s3API
+	Uploader *s3manager.Uploader
+	State    *uploadState
+
+	// resumable is populated once by resumePendingUploads before Upload
+	// launches its parallel workers. PutObject deletes a key's entry once
+	// the resumed upload completes, so a retried upload for the same key
+	// falls back to a fresh s3manager upload instead of reusing a completed
+	// UploadId; an entry whose resume failed is kept, so a retry resumes
+	// the same UploadId again. resumableMu guards those concurrent
+	// reads/deletes.
+	resumableMu sync.Mutex
+	resumable   map[string]resumableUpload
+}
+
+func newS3Backend(cfg *Config) (*s3Backend, error) {
+	b := &s3Backend{Config: cfg}
+
+	client, err := b.newSession()
+	if err != nil {
+		return nil, err
+	}
+	b.Conn = client
+
+	sourcePath, err := sourcePathOf(cfg)
+	if err != nil {
+		return nil, err
+	}
+	b.State, err = loadUploadState(sourcePath)
+	if err != nil {
+		return nil, err
+	}
+
+	// Record each multipart UploadId as soon as S3 hands it back, so a
+	// run that gets killed mid-upload still leaves a trail for
+	// resumePendingUploads to find on the next invocation.
+	client.Handlers.Unmarshal.PushBack(recordMultipartUpload(b.State, sourcePath))
+
+	b.Uploader = s3manager.NewUploaderWithClient(client, func(u *s3manager.Uploader) {
+		if cfg.S3.PartSize > 0 {
+			u.PartSize = cfg.S3.PartSize
+		}
+		if cfg.S3.Concurrency > 0 {
+			u.Concurrency = cfg.S3.Concurrency
+		}
+		u.LeavePartsOnError = cfg.S3.LeavePartsOnError
+	})
+
+	return b, nil
+}
+
+func (b *s3Backend) newSession() (*s3.S3, error) {
+	cfg := b.Config
+
+	awsConfig := &aws.Config{}
+	if cfg.S3.Endpoint != "" {
+		awsConfig.Endpoint = aws.String(cfg.S3.Endpoint)
+		awsConfig.S3ForcePathStyle = aws.Bool(cfg.S3.ForcePathStyle)
+	}
+
+	sess, err := session.NewSession(awsConfig)
+	if err != nil {
+		return nil, err
+	}
+	sess.Config.WithCredentials(credentials.NewStaticCredentials(cfg.S3.AccessKey, cfg.S3.SecretKey, ""))
+
+	region := cfg.S3.Region
+	if region == "" && cfg.S3.Endpoint == "" {
+		region, err = s3manager.GetBucketRegion(context.Background(), sess, cfg.S3.Bucket, "us-west-2")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if region == "" {
+		return nil, errors.New("unknown region")
+	}
+	sess.Config.WithRegion(region)
+
+	return s3.New(sess), nil
+}
+
+func (b *s3Backend) PutObject(key string, body io.ReadSeeker, opts PutOptions) (string, error) {
+	b.resumableMu.Lock()
+	resume, ok := b.resumable[key]
+	b.resumableMu.Unlock()
+
+	if ok {
+		etag, err := b.resumeUpload(key, body, resume)
+		if err == nil {
+			// Done with this UploadId; a retried upload for this key
+			// (e.g. after an ETag mismatch) must start a fresh one
+			// instead of re-completing one S3 has already finished.
+			b.resumableMu.Lock()
+			delete(b.resumable, key)
+			b.resumableMu.Unlock()
+		}
+		return etag, err
+	}
+
+	input := &s3manager.UploadInput{
+		Bucket:      aws.String(b.Config.S3.Bucket),
+		Key:         aws.String(key),
+		ACL:         aws.String(opts.ACL),
+		ContentType: aws.String(opts.ContentType),
+		Body:        body,
+	}
+	if opts.CacheControl != "" {
+		input.CacheControl = aws.String(opts.CacheControl)
+	}
+	if opts.ContentEncoding != "" {
+		input.ContentEncoding = aws.String(opts.ContentEncoding)
+	}
+	if opts.ContentDisposition != "" {
+		input.ContentDisposition = aws.String(opts.ContentDisposition)
+	}
+	if len(opts.Metadata) > 0 {
+		input.Metadata = aws.StringMap(opts.Metadata)
+	}
+
+	out, err := b.Uploader.Upload(input)
+	if err != nil {
+		return "", err
+	}
+	b.State.clear(key)
+	if err := b.State.save(b.sourcePath()); err != nil {
+		return "", err
+	}
+	return trimETag(aws.StringValue(out.ETag)), nil
+}
+
+// resumeUpload continues a multipart upload resumePendingUploads found
+// already in progress on S3, reusing resume.UploadID instead of starting
+// a new one. Parts ListParts already reported are reused as-is (their
+// bytes are still read from body to skip past them, but not sent again);
+// every other part is split at the same
+// Config.S3.PartSize localETag uses and sent with UploadPart, so this
+// relies on PartSize being unchanged since the interrupted run that
+// created the upload. Note that per-object options (ACL, CacheControl,
+// ...) were already fixed by the original CreateMultipartUpload call and
+// can't be changed on resume.
+func (b *s3Backend) resumeUpload(key string, body io.ReadSeeker, resume resumableUpload) (string, error) {
+	partSize := b.Config.S3.PartSize
+	if partSize <= 0 {
+		partSize = s3manager.DefaultUploadPartSize
+	}
+
+	done := make(map[int64]*s3.Part, len(resume.Parts))
+	for _, p := range resume.Parts {
+		done[aws.Int64Value(p.PartNumber)] = p
+	}
+
+	var completed []*s3.CompletedPart
+	buf := make([]byte, partSize)
+	for partNumber := int64(1); ; partNumber++ {
+		n, readErr := io.ReadFull(body, buf)
+		if n == 0 {
+			if readErr == io.EOF {
+				break
+			}
+			return "", readErr
+		}
+
+		if existing, ok := done[partNumber]; ok {
+			completed = append(completed, &s3.CompletedPart{ETag: existing.ETag, PartNumber: existing.PartNumber})
+		} else {
+			out, err := b.Conn.UploadPart(&s3.UploadPartInput{
+				Bucket:     aws.String(b.Config.S3.Bucket),
+				Key:        aws.String(key),
+				UploadId:   aws.String(resume.UploadID),
+				PartNumber: aws.Int64(partNumber),
+				Body:       bytes.NewReader(buf[:n]),
+			})
+			if err != nil {
+				return "", err
+			}
+			completed = append(completed, &s3.CompletedPart{ETag: out.ETag, PartNumber: aws.Int64(partNumber)})
+		}
+
+		if readErr == io.ErrUnexpectedEOF {
+			break
+		}
+		if readErr != nil && readErr != io.EOF {
+			return "", readErr
+		}
+	}
+
+	out, err := b.Conn.CompleteMultipartUpload(&s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(b.Config.S3.Bucket),
+		Key:             aws.String(key),
+		UploadId:        aws.String(resume.UploadID),
+		MultipartUpload: &s3.CompletedMultipartUpload{Parts: completed},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	b.State.clear(key)
+	if err := b.State.save(b.sourcePath()); err != nil {
+		return "", err
+	}
+	return trimETag(aws.StringValue(out.ETag)), nil
+}
+
+func (b *s3Backend) HeadObject(key string) (string, bool, error) {
+	out, err := b.Conn.HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(b.Config.S3.Bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.RequestFailure); ok && aerr.StatusCode() == 404 {
+			return "", false, nil
+		}
+		return "", false, err
+	}
+	return trimETag(aws.StringValue(out.ETag)), true, nil
+}
+
+func (b *s3Backend) ListObjects(prefix string) ([]string, error) {
+	var keys []string
+	err := b.Conn.ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket: aws.String(b.Config.S3.Bucket),
+		Prefix: aws.String(prefix),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, obj := range page.Contents {
+			keys = append(keys, aws.StringValue(obj.Key))
+		}
+		return true
+	})
+	return keys, err
+}
+
+func (b *s3Backend) DeleteObjects(keys []string) error {
+	for len(keys) > 0 {
+		batch := keys
+		if len(batch) > 1000 {
+			batch = keys[:1000]
+		}
+
+		objects := make([]*s3.ObjectIdentifier, len(batch))
+		for i, key := range batch {
+			objects[i] = &s3.ObjectIdentifier{Key: aws.String(key)}
+		}
+
+		if _, err := b.Conn.DeleteObjects(&s3.DeleteObjectsInput{
+			Bucket: aws.String(b.Config.S3.Bucket),
+			Delete: &s3.Delete{Objects: objects},
+		}); err != nil {
+			return err
+		}
+		keys = keys[len(batch):]
+	}
+	return nil
+}
+
+// resumePendingUploads looks at multipart uploads left behind by an
+// interrupted run. For each one still live on S3 with at least one part
+// already landed (via ListParts), it registers a resumableUpload so the
+// matching PutObject call continues that same UploadId part-by-part
+// instead of re-uploading the whole object. Uploads that are gone or
+// superseded are just dropped from state. A live upload that never got a
+// single part through is dropped too and, unless
+// Config.S3.LeavePartsOnError is set, aborted so the bucket doesn't
+// accumulate incomplete uploads across runs.
+func (b *s3Backend) resumePendingUploads() error {
+	tracked := b.State.pending()
+	if len(tracked) == 0 {
+		return nil
+	}
+
+	out, err := b.Conn.ListMultipartUploads(&s3.ListMultipartUploadsInput{
+		Bucket: aws.String(b.Config.S3.Bucket),
+		Prefix: aws.String(destPrefix(b.Config)),
+	})
+	if err != nil {
+		return err
+	}
+
+	live := make(map[string]string) // key -> UploadId
+	for _, u := range out.Uploads {
+		live[aws.StringValue(u.Key)] = aws.StringValue(u.UploadId)
+	}
+
+	b.resumable = make(map[string]resumableUpload)
+
+	for key, uploadID := range tracked {
+		liveID, ok := live[key]
+		if !ok || liveID != uploadID {
+			// The upload is gone or was superseded; nothing to resume.
+			b.State.clear(key)
+			continue
+		}
+
+		parts, err := b.Conn.ListParts(&s3.ListPartsInput{
+			Bucket:   aws.String(b.Config.S3.Bucket),
+			Key:      aws.String(key),
+			UploadId: aws.String(uploadID),
+		})
+		if err != nil {
+			return err
+		}
+		fmt.Printf("found incomplete upload for %s (%d parts already uploaded)\n", key, len(parts.Parts))
+
+		if len(parts.Parts) > 0 {
+			// Leave it in place on S3 and keep tracking it; PutObject
+			// will pick it up from resumable and finish it part by part.
+			b.resumable[key] = resumableUpload{UploadID: uploadID, Parts: parts.Parts}
+			continue
+		}
+
+		if !b.Config.S3.LeavePartsOnError {
+			_, err := b.Conn.AbortMultipartUpload(&s3.AbortMultipartUploadInput{
+				Bucket:   aws.String(b.Config.S3.Bucket),
+				Key:      aws.String(key),
+				UploadId: aws.String(uploadID),
+			})
+			if err != nil {
+				return err
+			}
+		}
+		b.State.clear(key)
+	}
+
+	return b.State.save(b.sourcePath())
+}
+
+func (b *s3Backend) sourcePath() string {
+	path, _ := sourcePathOf(b.Config)
+	return path
+}
+
+// recordMultipartUpload returns a request.Handler suitable for
+// Handlers.Unmarshal.PushBack that saves a newly created multipart
+// upload's UploadId into state as soon as S3's response has been
+// unmarshalled (on Handlers.Send, r.Data is still empty), split out of
+// newS3Backend so it can be exercised directly with a fake
+// *request.Request instead of a live SDK call.
+func recordMultipartUpload(state *uploadState, sourcePath string) func(*request.Request) {
+	return func(r *request.Request) {
+		if r.Error != nil || r.Operation == nil || r.Operation.Name != "CreateMultipartUpload" {
+			return
+		}
+		out, ok := r.Data.(*s3.CreateMultipartUploadOutput)
+		if !ok {
+			return
+		}
+		state.set(aws.StringValue(out.Key), aws.StringValue(out.UploadId))
+		state.save(sourcePath)
+	}
+}
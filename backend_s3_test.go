@@ -0,0 +1,455 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// fakeS3API is an in-memory s3API used to exercise s3Backend's own logic
+// (HeadObject, ListObjects, resumePendingUploads, DeleteObjects, and the
+// manual resume path in PutObject) without a real S3 client.
+type fakeS3API struct {
+	mu sync.Mutex
+
+	objects map[string][]byte // key -> body
+
+	multipartUploads map[string]string     // key -> UploadId, still "in progress"
+	parts            map[string][]*s3.Part // UploadId -> parts already landed
+	aborted          []string              // UploadIds passed to AbortMultipartUpload
+	completed        map[string][]*s3.CompletedPart
+}
+
+func newFakeS3API() *fakeS3API {
+	return &fakeS3API{
+		objects:          map[string][]byte{},
+		multipartUploads: map[string]string{},
+		parts:            map[string][]*s3.Part{},
+		completed:        map[string][]*s3.CompletedPart{},
+	}
+}
+
+func etagOf(data []byte) string {
+	return fmt.Sprintf("%x", md5.Sum(data))
+}
+
+func (f *fakeS3API) HeadObject(in *s3.HeadObjectInput) (*s3.HeadObjectOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	data, ok := f.objects[aws.StringValue(in.Key)]
+	if !ok {
+		return nil, awserrNotFound()
+	}
+	return &s3.HeadObjectOutput{ETag: aws.String(etagOf(data))}, nil
+}
+
+func (f *fakeS3API) ListObjectsV2Pages(in *s3.ListObjectsV2Input, fn func(*s3.ListObjectsV2Output, bool) bool) error {
+	f.mu.Lock()
+	var objs []*s3.Object
+	for key := range f.objects {
+		if strings.HasPrefix(key, aws.StringValue(in.Prefix)) {
+			objs = append(objs, &s3.Object{Key: aws.String(key)})
+		}
+	}
+	f.mu.Unlock()
+	fn(&s3.ListObjectsV2Output{Contents: objs}, true)
+	return nil
+}
+
+func (f *fakeS3API) ListMultipartUploads(in *s3.ListMultipartUploadsInput) (*s3.ListMultipartUploadsOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var uploads []*s3.MultipartUpload
+	for key, id := range f.multipartUploads {
+		if !strings.HasPrefix(key, aws.StringValue(in.Prefix)) {
+			continue
+		}
+		uploads = append(uploads, &s3.MultipartUpload{Key: aws.String(key), UploadId: aws.String(id)})
+	}
+	return &s3.ListMultipartUploadsOutput{Uploads: uploads}, nil
+}
+
+func (f *fakeS3API) ListParts(in *s3.ListPartsInput) (*s3.ListPartsOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return &s3.ListPartsOutput{Parts: f.parts[aws.StringValue(in.UploadId)]}, nil
+}
+
+// keyForUpload finds the key an in-progress UploadId belongs to. Must be
+// called with f.mu held.
+func (f *fakeS3API) keyForUpload(uploadID string) (string, bool) {
+	for key, id := range f.multipartUploads {
+		if id == uploadID {
+			return key, true
+		}
+	}
+	return "", false
+}
+
+func (f *fakeS3API) UploadPart(in *s3.UploadPartInput) (*s3.UploadPartOutput, error) {
+	data, err := io.ReadAll(in.Body)
+	if err != nil {
+		return nil, err
+	}
+	etag := etagOf(data)
+	uploadID := aws.StringValue(in.UploadId)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, live := f.keyForUpload(uploadID); !live {
+		return nil, fakeNoSuchUpload(uploadID)
+	}
+	f.parts[uploadID] = append(f.parts[uploadID], &s3.Part{
+		PartNumber: in.PartNumber,
+		ETag:       aws.String(etag),
+		Size:       aws.Int64(int64(len(data))),
+	})
+
+	return &s3.UploadPartOutput{ETag: aws.String(etag)}, nil
+}
+
+func (f *fakeS3API) CompleteMultipartUpload(in *s3.CompleteMultipartUploadInput) (*s3.CompleteMultipartUploadOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	uploadID := aws.StringValue(in.UploadId)
+	key, live := f.keyForUpload(uploadID)
+	if !live {
+		return nil, fakeNoSuchUpload(uploadID)
+	}
+	f.completed[uploadID] = in.MultipartUpload.Parts
+	// A completed upload is no longer in progress, matching real S3:
+	// completing (or aborting) it a second time fails with NoSuchUpload.
+	delete(f.multipartUploads, key)
+	return &s3.CompleteMultipartUploadOutput{ETag: aws.String("final-etag")}, nil
+}
+
+func (f *fakeS3API) AbortMultipartUpload(in *s3.AbortMultipartUploadInput) (*s3.AbortMultipartUploadOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	uploadID := aws.StringValue(in.UploadId)
+	f.aborted = append(f.aborted, uploadID)
+	if key, live := f.keyForUpload(uploadID); live {
+		delete(f.multipartUploads, key)
+	}
+	return &s3.AbortMultipartUploadOutput{}, nil
+}
+
+// fakeNoSuchUpload stands in for the NoSuchUpload error real S3 returns
+// when a multipart operation references an UploadId that's already been
+// completed or aborted. It is a plain error, not an awserr.Error.
+func fakeNoSuchUpload(uploadID string) error {
+	return fmt.Errorf("NoSuchUpload: upload %s is not in progress", uploadID)
+}
+
+func (f *fakeS3API) DeleteObjects(in *s3.DeleteObjectsInput) (*s3.DeleteObjectsOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, o := range in.Delete.Objects {
+		delete(f.objects, aws.StringValue(o.Key))
+	}
+	return &s3.DeleteObjectsOutput{}, nil
+}
+
+var _ s3API = (*fakeS3API)(nil)
+
+func testS3Backend(t *testing.T, conn *fakeS3API) *s3Backend {
+	t.Helper()
+	cfg := &Config{}
+	cfg.S3.Bucket = "test-bucket"
+	cfg.S3.Prefix = "/"
+	return &s3Backend{
+		Config: cfg,
+		Conn:   conn,
+		State:  &uploadState{Uploads: map[string]string{}},
+	}
+}
+
+func awserrNotFound() error {
+	return &fakeAWSRequestFailure{statusCode: 404}
+}
+
+// fakeAWSRequestFailure is a minimal awserr.RequestFailure, enough for
+// s3Backend.HeadObject's 404-means-missing check.
+type fakeAWSRequestFailure struct{ statusCode int }
+
+func (e *fakeAWSRequestFailure) Code() string      { return "NotFound" }
+func (e *fakeAWSRequestFailure) Message() string   { return "not found" }
+func (e *fakeAWSRequestFailure) Error() string     { return "not found" }
+func (e *fakeAWSRequestFailure) OrigErr() error    { return nil }
+func (e *fakeAWSRequestFailure) StatusCode() int   { return e.statusCode }
+func (e *fakeAWSRequestFailure) RequestID() string { return "fake-request-id" }
+
+func TestS3BackendHeadObject(t *testing.T) {
+	conn := newFakeS3API()
+	conn.objects["/index.html"] = []byte("hi")
+	b := testS3Backend(t, conn)
+
+	etag, ok, err := b.HeadObject("/index.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok || etag != etagOf([]byte("hi")) {
+		t.Errorf("HeadObject() = %q, %v, want %q, true", etag, ok, etagOf([]byte("hi")))
+	}
+
+	_, ok, err = b.HeadObject("/missing.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok {
+		t.Error("HeadObject() of a missing key should report ok = false, not an error")
+	}
+}
+
+func TestS3BackendListObjects(t *testing.T) {
+	conn := newFakeS3API()
+	conn.objects["/static/app.js"] = []byte("js")
+	conn.objects["/static/app.css"] = []byte("css")
+	conn.objects["/other/file.txt"] = []byte("txt")
+	b := testS3Backend(t, conn)
+
+	keys, err := b.ListObjects("/static")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := map[string]bool{}
+	for _, k := range keys {
+		got[k] = true
+	}
+	if !got["/static/app.js"] || !got["/static/app.css"] || got["/other/file.txt"] {
+		t.Errorf("ListObjects(%q) = %v, want only the /static/* keys", "/static", keys)
+	}
+}
+
+func TestS3BackendDeleteObjects(t *testing.T) {
+	conn := newFakeS3API()
+	conn.objects["/a.txt"] = []byte("a")
+	conn.objects["/b.txt"] = []byte("b")
+	b := testS3Backend(t, conn)
+
+	if err := b.DeleteObjects([]string{"/a.txt"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := conn.objects["/a.txt"]; ok {
+		t.Error("DeleteObjects() did not remove /a.txt")
+	}
+	if _, ok := conn.objects["/b.txt"]; !ok {
+		t.Error("DeleteObjects() removed /b.txt, which wasn't in the request")
+	}
+}
+
+func TestResumePendingUploadsTracksLiveUploadWithParts(t *testing.T) {
+	conn := newFakeS3API()
+	conn.multipartUploads["/big.bin"] = "upload-1"
+	conn.parts["upload-1"] = []*s3.Part{{PartNumber: aws.Int64(1), ETag: aws.String("etag1"), Size: aws.Int64(5)}}
+
+	b := testS3Backend(t, conn)
+	b.State.Uploads["/big.bin"] = "upload-1"
+	sourcePath := t.TempDir()
+	b.Config.S3.Source = sourcePath
+
+	if err := b.resumePendingUploads(); err != nil {
+		t.Fatal(err)
+	}
+
+	resume, ok := b.resumable["/big.bin"]
+	if !ok {
+		t.Fatal("expected /big.bin to be registered as resumable")
+	}
+	if resume.UploadID != "upload-1" || len(resume.Parts) != 1 {
+		t.Errorf("resumable[/big.bin] = %+v, want UploadID upload-1 with 1 part", resume)
+	}
+	if len(conn.aborted) != 0 {
+		t.Errorf("expected a resumable upload not to be aborted, got aborted = %v", conn.aborted)
+	}
+	if _, stillTracked := b.State.Uploads["/big.bin"]; !stillTracked {
+		t.Error("expected /big.bin to remain tracked in state until PutObject completes it")
+	}
+}
+
+func TestResumePendingUploadsRespectsConfiguredPrefix(t *testing.T) {
+	conn := newFakeS3API()
+	// Real keys are always built as filepath.Join("/", Prefix, path), so
+	// a "assets" prefix (no leading slash, the normal way to write one in
+	// config) still lands at "/assets/big.bin" on S3.
+	conn.multipartUploads["/assets/big.bin"] = "upload-1"
+	conn.parts["upload-1"] = []*s3.Part{{PartNumber: aws.Int64(1), ETag: aws.String("etag1"), Size: aws.Int64(5)}}
+
+	b := testS3Backend(t, conn)
+	b.Config.S3.Prefix = "assets"
+	b.State.Uploads["/assets/big.bin"] = "upload-1"
+	b.Config.S3.Source = t.TempDir()
+
+	if err := b.resumePendingUploads(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := b.resumable["/assets/big.bin"]; !ok {
+		t.Fatal("expected /assets/big.bin to be registered as resumable; ListMultipartUploads must be called with the normalized, leading-slash prefix")
+	}
+	if len(conn.aborted) != 0 {
+		t.Errorf("expected a resumable upload not to be aborted, got aborted = %v", conn.aborted)
+	}
+}
+
+func TestResumePendingUploadsAbortsEmptyUpload(t *testing.T) {
+	conn := newFakeS3API()
+	conn.multipartUploads["/empty.bin"] = "upload-2"
+	// No parts landed for upload-2.
+
+	b := testS3Backend(t, conn)
+	b.State.Uploads["/empty.bin"] = "upload-2"
+	b.Config.S3.Source = t.TempDir()
+
+	if err := b.resumePendingUploads(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := b.resumable["/empty.bin"]; ok {
+		t.Error("an upload with zero landed parts should not be registered as resumable")
+	}
+	if len(conn.aborted) != 1 || conn.aborted[0] != "upload-2" {
+		t.Errorf("expected upload-2 to be aborted, got %v", conn.aborted)
+	}
+	if _, stillTracked := b.State.Uploads["/empty.bin"]; stillTracked {
+		t.Error("expected /empty.bin to be cleared from state after abort")
+	}
+}
+
+func TestResumePendingUploadsClearsSupersededUpload(t *testing.T) {
+	conn := newFakeS3API() // no live multipart uploads on S3 at all
+
+	b := testS3Backend(t, conn)
+	b.State.Uploads["/gone.bin"] = "upload-stale"
+	b.Config.S3.Source = t.TempDir()
+
+	if err := b.resumePendingUploads(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, stillTracked := b.State.Uploads["/gone.bin"]; stillTracked {
+		t.Error("expected a no-longer-live upload to be cleared from state")
+	}
+}
+
+func TestPutObjectResumesTrackedUpload(t *testing.T) {
+	conn := newFakeS3API()
+	conn.multipartUploads["/big.bin"] = "upload-1" // still in progress on S3
+	b := testS3Backend(t, conn)
+	b.Config.S3.PartSize = 4
+	b.Config.S3.Source = t.TempDir()
+
+	// Simulate part 1 already landed on a previous, interrupted run.
+	b.resumable = map[string]resumableUpload{
+		"/big.bin": {
+			UploadID: "upload-1",
+			Parts:    []*s3.Part{{PartNumber: aws.Int64(1), ETag: aws.String("etag1"), Size: aws.Int64(4)}},
+		},
+	}
+	b.State.Uploads["/big.bin"] = "upload-1"
+
+	body := bytes.NewReader([]byte("aaaabbbb")) // two 4-byte parts
+	if _, err := b.PutObject("/big.bin", body, PutOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	completed := conn.completed["upload-1"]
+	if len(completed) != 2 {
+		t.Fatalf("expected CompleteMultipartUpload to be called with 2 parts, got %d", len(completed))
+	}
+	if aws.StringValue(completed[0].ETag) != "etag1" {
+		t.Errorf("expected part 1 to reuse the already-landed etag1, got %v", aws.StringValue(completed[0].ETag))
+	}
+	if _, uploadedPart2 := conn.parts["upload-1"]; !uploadedPart2 {
+		t.Error("expected part 2 to be uploaded via UploadPart since it wasn't already landed")
+	}
+	if _, stillTracked := b.State.Uploads["/big.bin"]; stillTracked {
+		t.Error("expected /big.bin to be cleared from state once the resume completes")
+	}
+	if _, stillResumable := b.resumable["/big.bin"]; stillResumable {
+		t.Error("expected /big.bin's resumable entry to be cleared once the resume completes")
+	}
+}
+
+func TestPutObjectDoesNotReuseACompletedResume(t *testing.T) {
+	conn := newFakeS3API()
+	conn.multipartUploads["/big.bin"] = "upload-1"
+	b := testS3Backend(t, conn)
+	b.Config.S3.PartSize = 4
+	b.Config.S3.Source = t.TempDir()
+
+	resume := resumableUpload{UploadID: "upload-1"}
+	b.resumable = map[string]resumableUpload{"/big.bin": resume}
+	b.State.Uploads["/big.bin"] = "upload-1"
+
+	if _, err := b.PutObject("/big.bin", bytes.NewReader([]byte("aaaa")), PutOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A real S3 would now reject this UploadId with NoSuchUpload since
+	// it was already completed; resumeUpload must not be re-entered with
+	// a stale resumable entry on a later retry of the same key.
+	if _, err := b.resumeUpload("/big.bin", bytes.NewReader([]byte("aaaa")), resume); err == nil {
+		t.Fatal("expected resumeUpload to fail against an already-completed UploadId")
+	}
+	if _, ok := b.resumable["/big.bin"]; ok {
+		t.Error("expected the resumable entry to be gone after the first PutObject succeeded")
+	}
+}
+
+func TestRecordMultipartUploadSavesUploadId(t *testing.T) {
+	sourcePath := t.TempDir()
+	state, err := loadUploadState(sourcePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler := recordMultipartUpload(state, sourcePath)
+	handler(&request.Request{
+		Operation: &request.Operation{Name: "CreateMultipartUpload"},
+		Data: &s3.CreateMultipartUploadOutput{
+			Key:      aws.String("/big.bin"),
+			UploadId: aws.String("upload-1"),
+		},
+	})
+
+	if got := state.Uploads["/big.bin"]; got != "upload-1" {
+		t.Errorf("state.Uploads[/big.bin] = %q, want upload-1", got)
+	}
+
+	reloaded, err := loadUploadState(sourcePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := reloaded.Uploads["/big.bin"]; got != "upload-1" {
+		t.Errorf("persisted state.Uploads[/big.bin] = %q, want upload-1", got)
+	}
+}
+
+func TestRecordMultipartUploadIgnoresOtherOperations(t *testing.T) {
+	sourcePath := t.TempDir()
+	state, err := loadUploadState(sourcePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler := recordMultipartUpload(state, sourcePath)
+	handler(&request.Request{
+		Operation: &request.Operation{Name: "PutObject"},
+		Data:      &s3.PutObjectOutput{},
+	})
+
+	if len(state.Uploads) != 0 {
+		t.Errorf("expected non-CreateMultipartUpload operations to be ignored, got %v", state.Uploads)
+	}
+}
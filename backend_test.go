@@ -0,0 +1,39 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNewBackendDispatchesByScheme(t *testing.T) {
+	t.Run("file", func(t *testing.T) {
+		cfg := &Config{}
+		cfg.S3.Destination = "file://" + filepath.Join(t.TempDir(), "out")
+		b, err := newBackend(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := b.(*fileBackend); !ok {
+			t.Errorf("newBackend(file://...) = %T, want *fileBackend", b)
+		}
+	})
+
+	t.Run("gs is explicitly unimplemented", func(t *testing.T) {
+		cfg := &Config{}
+		cfg.S3.Destination = "gs://some-bucket"
+		_, err := newBackend(cfg)
+		if err == nil || !strings.Contains(err.Error(), "gs://") {
+			t.Errorf("newBackend(gs://...) error = %v, want an error naming gs:// as unimplemented", err)
+		}
+	})
+
+	t.Run("unknown scheme", func(t *testing.T) {
+		cfg := &Config{}
+		cfg.S3.Destination = "ftp://host/path"
+		_, err := newBackend(cfg)
+		if err == nil {
+			t.Error("newBackend(ftp://...) expected an error for an unsupported scheme")
+		}
+	})
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cloudfront"
+)
+
+// cloudfrontAPI is the subset of the CloudFront client s3up needs,
+// narrow enough to fake in tests.
+type cloudfrontAPI interface {
+	CreateInvalidation(*cloudfront.CreateInvalidationInput) (*cloudfront.CreateInvalidationOutput, error)
+}
+
+func newCloudFrontClient() (cloudfrontAPI, error) {
+	sess, err := session.NewSession()
+	if err != nil {
+		return nil, err
+	}
+	return cloudfront.New(sess), nil
+}
+
+// invalidateCloudFront issues a CloudFront invalidation covering the
+// given changed keys, or "/*" once the change count crosses
+// Config.CloudFront.InvalidateAllThreshold, so a large deploy doesn't
+// submit thousands of individual paths in one request. A no-op when
+// Config.CloudFront.DistributionID isn't set.
+func invalidateCloudFront(cfg *Config, changedKeys []string) error {
+	if cfg.CloudFront.DistributionID == "" || len(changedKeys) == 0 {
+		return nil
+	}
+
+	client, err := newCloudFrontClient()
+	if err != nil {
+		return err
+	}
+
+	return invalidateCloudFrontWith(client, cfg, changedKeys)
+}
+
+// invalidateCloudFrontWith does the actual invalidation call against
+// client, split out from invalidateCloudFront so tests can drive it with
+// a fake cloudfrontAPI instead of a real session.
+func invalidateCloudFrontWith(client cloudfrontAPI, cfg *Config, changedKeys []string) error {
+	threshold := cfg.CloudFront.InvalidateAllThreshold
+	if threshold <= 0 {
+		threshold = 50
+	}
+
+	paths := changedKeys
+	if len(changedKeys) > threshold {
+		paths = []string{"/*"}
+	}
+
+	items := make([]*string, len(paths))
+	for i, p := range paths {
+		items[i] = aws.String(p)
+	}
+
+	fmt.Printf("invalidating %d CloudFront path(s) ...\n", len(paths))
+
+	_, err := client.CreateInvalidation(&cloudfront.CreateInvalidationInput{
+		DistributionId: aws.String(cfg.CloudFront.DistributionID),
+		InvalidationBatch: &cloudfront.InvalidationBatch{
+			CallerReference: aws.String(fmt.Sprintf("s3up-%d", time.Now().UnixNano())),
+			Paths: &cloudfront.Paths{
+				Quantity: aws.Int64(int64(len(paths))),
+				Items:    items,
+			},
+		},
+	})
+	return err
+}
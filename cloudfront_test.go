@@ -0,0 +1,71 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudfront"
+)
+
+// fakeCloudfrontAPI is an in-memory cloudfrontAPI used to exercise
+// invalidateCloudFrontWith without a real CloudFront client.
+type fakeCloudfrontAPI struct {
+	invalidations []*cloudfront.CreateInvalidationInput
+}
+
+func (f *fakeCloudfrontAPI) CreateInvalidation(in *cloudfront.CreateInvalidationInput) (*cloudfront.CreateInvalidationOutput, error) {
+	f.invalidations = append(f.invalidations, in)
+	return &cloudfront.CreateInvalidationOutput{}, nil
+}
+
+func invalidationPaths(in *cloudfront.CreateInvalidationInput) []string {
+	var paths []string
+	for _, p := range in.InvalidationBatch.Paths.Items {
+		paths = append(paths, aws.StringValue(p))
+	}
+	return paths
+}
+
+func TestInvalidateCloudFrontListsChangedPaths(t *testing.T) {
+	client := &fakeCloudfrontAPI{}
+	cfg := &Config{}
+	cfg.CloudFront.DistributionID = "E123"
+
+	if err := invalidateCloudFrontWith(client, cfg, []string{"/a.html", "/b.html"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(client.invalidations) != 1 {
+		t.Fatalf("expected 1 CreateInvalidation call, got %d", len(client.invalidations))
+	}
+	got := invalidationPaths(client.invalidations[0])
+	if len(got) != 2 || got[0] != "/a.html" || got[1] != "/b.html" {
+		t.Errorf("invalidation paths = %v, want [/a.html /b.html]", got)
+	}
+}
+
+func TestInvalidateCloudFrontInvalidatesAllPastThreshold(t *testing.T) {
+	client := &fakeCloudfrontAPI{}
+	cfg := &Config{}
+	cfg.CloudFront.DistributionID = "E123"
+	cfg.CloudFront.InvalidateAllThreshold = 2
+
+	if err := invalidateCloudFrontWith(client, cfg, []string{"/a.html", "/b.html", "/c.html"}); err != nil {
+		t.Fatal(err)
+	}
+
+	got := invalidationPaths(client.invalidations[0])
+	if len(got) != 1 || got[0] != "/*" {
+		t.Errorf("invalidation paths = %v, want [/*] once the threshold is exceeded", got)
+	}
+}
+
+func TestInvalidateCloudFrontNoopWithoutDistributionID(t *testing.T) {
+	cfg := &Config{}
+
+	// No DistributionID set, so this must return before ever trying to
+	// build a real CloudFront client/session.
+	if err := invalidateCloudFront(cfg, []string{"/a.html"}); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+// Config holds everything s3up needs to run a single upload: where the
+// files come from, where they go, and how they should be handled once
+// they get there. One Config is built per invocation and threaded through
+// S3Upload and its Backend.
+type Config struct {
+	S3         S3Config
+	CloudFront CloudFrontConfig
+}
+
+// S3Config groups the destination, credentials, and upload behavior for
+// a single bucket (or S3-compatible endpoint).
+type S3Config struct {
+	Source string // local directory to upload from
+	Prefix string // key prefix objects are uploaded under
+
+	// Destination selects the Backend via a URL scheme: s3:// (the
+	// default) or file:// for local dry-testing. When empty, the s3
+	// backend is used with Bucket/Region/Endpoint below.
+	Destination string
+
+	Bucket    string
+	Region    string
+	AccessKey string
+	SecretKey string
+
+	// Endpoint and ForcePathStyle point the s3 backend at an
+	// S3-compatible service (MinIO, Wasabi, DigitalOcean Spaces,
+	// Backblaze B2) instead of AWS.
+	Endpoint       string
+	ForcePathStyle bool
+
+	ACL          string
+	CacheControl string
+	Ignore       []string
+	Rules        []UploadRule
+
+	Sync   bool // skip files whose remote ETag already matches
+	Delete bool // remove remote objects with no local counterpart
+
+	// VerifyRetries caps how many upload attempts putVerified makes in
+	// all, the first one included, while the remote ETag doesn't match
+	// the local hash. Defaults to 3.
+	VerifyRetries int
+
+	// PartSize, Concurrency, and LeavePartsOnError configure the
+	// underlying s3manager.Uploader. PartSize also determines the
+	// multipart split points localETag uses to compute the expected
+	// multipart ETag, so it must match whatever the uploader actually
+	// used.
+	PartSize          int64
+	Concurrency       int
+	LeavePartsOnError bool
+}
+
+// CloudFrontConfig configures the optional post-upload invalidation.
+type CloudFrontConfig struct {
+	// DistributionID enables invalidation when set; leaving it empty
+	// skips the CloudFront call entirely.
+	DistributionID string
+
+	// InvalidateAllThreshold is the number of changed keys above which
+	// s3up invalidates "/*" instead of listing every path. Defaults to
+	// 50.
+	InvalidateAllThreshold int
+}
@@ -0,0 +1,60 @@
+package main
+
+import "github.com/mattn/go-zglob"
+
+// UploadRule lets a config replace the single global ACL/CacheControl/
+// Content-Type with per-file overrides, e.g. long-lived caching for
+// fingerprinted static assets but no-cache for the HTML that references
+// them.
+type UploadRule struct {
+	Match              string
+	ACL                string
+	ContentType        string
+	CacheControl       string
+	ContentEncoding    string
+	ContentDisposition string
+	Metadata           map[string]string
+}
+
+// rulesFor walks Config.S3.Rules in order, the same way isUploadableFile
+// walks Config.S3.Ignore, and merges every rule whose Match glob matches
+// path into a single PutOptions. Later rules take precedence field by
+// field over earlier ones; Metadata keys are merged rather than replaced
+// wholesale.
+func (s *S3Upload) rulesFor(path string) (PutOptions, error) {
+	var opts PutOptions
+
+	for _, rule := range s.Config.S3.Rules {
+		match, err := zglob.Match(rule.Match, path)
+		if err != nil {
+			return opts, err
+		}
+		if !match {
+			continue
+		}
+
+		if rule.ACL != "" {
+			opts.ACL = rule.ACL
+		}
+		if rule.ContentType != "" {
+			opts.ContentType = rule.ContentType
+		}
+		if rule.CacheControl != "" {
+			opts.CacheControl = rule.CacheControl
+		}
+		if rule.ContentEncoding != "" {
+			opts.ContentEncoding = rule.ContentEncoding
+		}
+		if rule.ContentDisposition != "" {
+			opts.ContentDisposition = rule.ContentDisposition
+		}
+		for k, v := range rule.Metadata {
+			if opts.Metadata == nil {
+				opts.Metadata = map[string]string{}
+			}
+			opts.Metadata[k] = v
+		}
+	}
+
+	return opts, nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRulesForNoMatch(t *testing.T) {
+	s := testUpload(t, newFakeBackend(), nil)
+	s.Config.S3.Rules = []UploadRule{
+		{Match: "*.log", ContentType: "text/plain"},
+	}
+
+	got, err := s.rulesFor("index.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, PutOptions{}) {
+		t.Errorf("rulesFor() = %+v, want zero value for a path no rule matches", got)
+	}
+}
+
+func TestRulesForSingleMatch(t *testing.T) {
+	s := testUpload(t, newFakeBackend(), nil)
+	s.Config.S3.Rules = []UploadRule{
+		{Match: "*.js", ContentType: "application/javascript", CacheControl: "max-age=31536000"},
+	}
+
+	got, err := s.rulesFor("app.js")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := PutOptions{ContentType: "application/javascript", CacheControl: "max-age=31536000"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("rulesFor() = %+v, want %+v", got, want)
+	}
+}
+
+func TestRulesForLaterRuleOverridesEarlierFieldByField(t *testing.T) {
+	s := testUpload(t, newFakeBackend(), nil)
+	s.Config.S3.Rules = []UploadRule{
+		{Match: "*", ACL: "public-read", ContentType: "application/octet-stream"},
+		{Match: "*.html", CacheControl: "no-cache"},
+	}
+
+	got, err := s.rulesFor("index.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The second rule doesn't set ACL or ContentType, so the first rule's
+	// values survive; it only overrides the field it actually sets.
+	want := PutOptions{ACL: "public-read", ContentType: "application/octet-stream", CacheControl: "no-cache"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("rulesFor() = %+v, want %+v", got, want)
+	}
+}
+
+func TestRulesForLaterRuleCanOverwriteSameField(t *testing.T) {
+	s := testUpload(t, newFakeBackend(), nil)
+	s.Config.S3.Rules = []UploadRule{
+		{Match: "*", ACL: "public-read"},
+		{Match: "*.secret", ACL: "private"},
+	}
+
+	got, err := s.rulesFor("creds.secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ACL != "private" {
+		t.Errorf("rulesFor().ACL = %q, want %q (later matching rule should win)", got.ACL, "private")
+	}
+}
+
+func TestRulesForMetadataMerges(t *testing.T) {
+	s := testUpload(t, newFakeBackend(), nil)
+	s.Config.S3.Rules = []UploadRule{
+		{Match: "*", Metadata: map[string]string{"team": "web", "env": "prod"}},
+		{Match: "*.html", Metadata: map[string]string{"env": "staging"}},
+	}
+
+	got, err := s.rulesFor("index.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"team": "web", "env": "staging"}
+	if !reflect.DeepEqual(got.Metadata, want) {
+		t.Errorf("rulesFor().Metadata = %+v, want %+v (merged, not replaced)", got.Metadata, want)
+	}
+}
@@ -1,9 +1,8 @@
 package main
 
 import (
-	"context"
-	"errors"
 	"fmt"
+	"io"
 	"mime"
 	"os"
 	"path/filepath"
@@ -12,60 +11,39 @@ import (
 	"sync/atomic"
 	"time"
 
-	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
-	"github.com/aws/aws-sdk-go/aws/credentials"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/s3"
-	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 	"github.com/mattn/go-zglob"
 )
 
 type S3Upload struct {
 	Config     *Config
-	Conn       *s3.S3
+	Backend    Backend
 	SourcePath string
 }
 
 func NewS3Upload(cfg *Config) (*S3Upload, error) {
-	var err error
-	s3c := &S3Upload{Config: cfg}
-	s3c.Conn, err = s3c.newSession()
+	backend, err := newBackend(cfg)
 	if err != nil {
 		return nil, err
 	}
-	s3c.SourcePath, err = filepath.Abs(cfg.S3.Source)
-	if err != nil {
-		return nil, err
-	}
-	return s3c, nil
-}
 
-func (s *S3Upload) newSession() (*s3.S3, error) {
-	cfg := s.Config
-
-	awsConfig := &aws.Config{}
-
-	sess, err := session.NewSession(awsConfig)
+	sourcePath, err := sourcePathOf(cfg)
 	if err != nil {
 		return nil, err
 	}
-	sess.Config.WithCredentials(credentials.NewStaticCredentials(cfg.S3.AccessKey, cfg.S3.SecretKey, ""))
 
-	region := cfg.S3.Region
-	if region == "" {
-		region, err = s3manager.GetBucketRegion(context.Background(), sess, cfg.S3.Bucket, "us-west-2")
-		if err != nil {
-			return nil, err
-		}
-	}
+	return &S3Upload{Config: cfg, Backend: backend, SourcePath: sourcePath}, nil
+}
 
-	if region == "" {
-		return nil, errors.New("unknown region")
-	}
-	sess.Config.WithRegion(region)
+func sourcePathOf(cfg *Config) (string, error) {
+	return filepath.Abs(cfg.S3.Source)
+}
 
-	return s3.New(sess), nil
+// resumer is implemented by backends (currently just s3Backend) that can
+// detect and clean up multipart uploads an earlier, interrupted run left
+// behind.
+type resumer interface {
+	resumePendingUploads() error
 }
 
 func (s *S3Upload) isUploadableFile(path string) (bool, error) {
@@ -124,7 +102,6 @@ func (s *S3Upload) sourceFiles() ([]string, error) {
 
 func (s *S3Upload) uploadFile(path string, dryrun bool) (int, error) {
 	num := 0
-	s3c := s.Conn
 
 	file, err := os.Open(filepath.Join(s.SourcePath, path))
 	if err != nil {
@@ -139,6 +116,17 @@ func (s *S3Upload) uploadFile(path string, dryrun bool) (int, error) {
 
 	destPath := filepath.Join("/", s.Config.S3.Prefix, path)
 
+	if s.Config.S3.Sync {
+		same, err := s.unchanged(filepath.Join(s.SourcePath, path), destPath)
+		if err != nil {
+			return num, err
+		}
+		if same {
+			fmt.Printf("skipping %s (unchanged)\n", destPath)
+			return num, nil
+		}
+	}
+
 	if dryrun {
 		fmt.Printf("[DRYRUN] uploading %s ...\n", destPath)
 	} else {
@@ -150,21 +138,31 @@ func (s *S3Upload) uploadFile(path string, dryrun bool) (int, error) {
 		acl = "private"
 	}
 
-	obj := &s3.PutObjectInput{
-		Bucket:      aws.String(s.Config.S3.Bucket),
-		Key:         aws.String(destPath),
-		ACL:         aws.String(acl),
-		ContentType: aws.String(mimeType),
-		Body:        file,
+	opts := PutOptions{
+		ACL:          acl,
+		ContentType:  mimeType,
+		CacheControl: s.Config.S3.CacheControl,
 	}
 
-	if s.Config.S3.CacheControl != "" {
-		obj.CacheControl = aws.String(s.Config.S3.CacheControl)
+	ruleOpts, err := s.rulesFor(path)
+	if err != nil {
+		return num, err
 	}
+	if ruleOpts.ACL != "" {
+		opts.ACL = ruleOpts.ACL
+	}
+	if ruleOpts.ContentType != "" {
+		opts.ContentType = ruleOpts.ContentType
+	}
+	if ruleOpts.CacheControl != "" {
+		opts.CacheControl = ruleOpts.CacheControl
+	}
+	opts.ContentEncoding = ruleOpts.ContentEncoding
+	opts.ContentDisposition = ruleOpts.ContentDisposition
+	opts.Metadata = ruleOpts.Metadata
 
 	if !dryrun {
-		req, _ := s3c.PutObjectRequest(obj)
-		if err := req.Send(); err != nil {
+		if err := s.putVerified(file, path, destPath, opts); err != nil {
 			return num, err
 		}
 		num += 1
@@ -173,7 +171,54 @@ func (s *S3Upload) uploadFile(path string, dryrun bool) (int, error) {
 	return num, nil
 }
 
+// putVerified uploads body and confirms the object S3 (or another
+// Backend) ended up with matches the local file, repeating the whole
+// upload while the ETags disagree, for at most Config.S3.VerifyRetries
+// attempts in all - guarding against a corrupted transfer landing
+// undetected.
+func (s *S3Upload) putVerified(file *os.File, path, destPath string, opts PutOptions) error {
+	localSum, err := s.localETag(filepath.Join(s.SourcePath, path))
+	if err != nil {
+		return err
+	}
+
+	attempts := s.Config.S3.VerifyRetries
+	if attempts <= 0 {
+		attempts = 3
+	}
+
+	var lastErr error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+
+		remoteSum, err := s.Backend.PutObject(destPath, file, opts)
+		if err != nil {
+			return err
+		}
+		if remoteSum == localSum {
+			return nil
+		}
+
+		lastErr = fmt.Errorf("integrity check failed for %s (local %s, remote %s)", destPath, localSum, remoteSum)
+		if attempt < attempts {
+			fmt.Printf("%v, retrying (%d/%d) ...\n", lastErr, attempt, attempts)
+		}
+	}
+
+	return lastErr
+}
+
 func (s *S3Upload) Upload(parallel int, dryrun bool) (uint64, error) {
+	if !dryrun {
+		if r, ok := s.Backend.(resumer); ok {
+			if err := r.resumePendingUploads(); err != nil {
+				return 0, err
+			}
+		}
+	}
+
 	files, err := s.sourceFiles()
 	if err != nil {
 		return 0, err
@@ -186,37 +231,62 @@ func (s *S3Upload) Upload(parallel int, dryrun bool) (uint64, error) {
 	close(fch)
 
 	var num uint64
+	errCh := make(chan error, len(files))
+
+	var changedMu sync.Mutex
+	var changedKeys []string
 
 	var wg sync.WaitGroup
 	for i := 0; i < parallel; i++ {
 		wg.Add(1)
-		go func(i int) {
+		go func() {
 			defer wg.Done()
 			for path := range fch {
 				numRetries := 30
 			RETRY:
 				n, err := s.uploadFile(path, dryrun)
 				if err != nil {
-					_, ok := err.(awserr.Error)
-					if ok {
-						numRetries -= 1
-						if numRetries > 0 {
-							// retry in 1 second
-							fmt.Printf("failed to upload %s, retrying in 1 second ...\n", path)
-							time.Sleep(1 * time.Second)
-							goto RETRY
-						} else {
-							panic(err)
-						}
-					} else {
-						panic(fmt.Sprintf("unknown error! %v", err))
+					if _, ok := err.(awserr.Error); ok && numRetries > 0 {
+						numRetries--
+						fmt.Printf("failed to upload %s, retrying in 1 second ...\n", path)
+						time.Sleep(1 * time.Second)
+						goto RETRY
 					}
+					errCh <- fmt.Errorf("%s: %w", path, err)
+					continue
+				}
+				if n > 0 {
+					changedMu.Lock()
+					changedKeys = append(changedKeys, filepath.Join("/", s.Config.S3.Prefix, path))
+					changedMu.Unlock()
 				}
 				atomic.AddUint64(&num, uint64(n))
 			}
-		}(i)
+		}()
 	}
 
 	wg.Wait()
+	close(errCh)
+
+	var errs []string
+	for err := range errCh {
+		errs = append(errs, err.Error())
+	}
+	if len(errs) > 0 {
+		return num, fmt.Errorf("%d file(s) failed to upload:\n%s", len(errs), strings.Join(errs, "\n"))
+	}
+
+	if s.Config.S3.Delete && !dryrun {
+		if err := s.deleteOrphaned(files); err != nil {
+			return num, err
+		}
+	}
+
+	if !dryrun {
+		if err := invalidateCloudFront(s.Config, changedKeys); err != nil {
+			return num, err
+		}
+	}
+
 	return num, nil
 }
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"crypto/md5"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+)
+
+// fakeBackend is an in-memory Backend used to exercise S3Upload without
+// touching real object storage.
+type fakeBackend struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	puts    []string
+	// failKeysN, when non-zero, makes the next N PutObject calls for that
+	// key fail before succeeding.
+	failKeysN map[string]int
+	failErr   error
+	// badEtagN, when non-zero, makes the next N PutObject calls for that
+	// key report a corrupted ETag (simulating a transfer that landed
+	// wrong) before reporting the real one.
+	badEtagN map[string]int
+}
+
+func newFakeBackend() *fakeBackend {
+	return &fakeBackend{
+		objects:   map[string][]byte{},
+		failKeysN: map[string]int{},
+		badEtagN:  map[string]int{},
+	}
+}
+
+func (b *fakeBackend) PutObject(key string, body io.ReadSeeker, opts PutOptions) (string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if n := b.failKeysN[key]; n > 0 {
+		b.failKeysN[key] = n - 1
+		return "", b.failErr
+	}
+
+	data, err := ioutil.ReadAll(body)
+	if err != nil {
+		return "", err
+	}
+	b.objects[key] = data
+	b.puts = append(b.puts, key)
+
+	if n := b.badEtagN[key]; n > 0 {
+		b.badEtagN[key] = n - 1
+		return "corrupted-etag", nil
+	}
+	return fmt.Sprintf("%x", md5.Sum(data)), nil
+}
+
+func (b *fakeBackend) HeadObject(key string) (string, bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	data, ok := b.objects[key]
+	if !ok {
+		return "", false, nil
+	}
+	return fmt.Sprintf("%x", md5.Sum(data)), true, nil
+}
+
+func (b *fakeBackend) ListObjects(prefix string) ([]string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var keys []string
+	for key := range b.objects {
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func (b *fakeBackend) DeleteObjects(keys []string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, key := range keys {
+		delete(b.objects, key)
+	}
+	return nil
+}
+
+// fakeAWSError is a minimal awserr.Error, used to exercise the
+// retry-on-AWS-error path without depending on a real SDK failure.
+type fakeAWSError struct{}
+
+func (fakeAWSError) Code() string    { return "InternalError" }
+func (fakeAWSError) Message() string { return "boom" }
+func (fakeAWSError) Error() string   { return "boom" }
+func (fakeAWSError) OrigErr() error  { return nil }
+
+var _ awserr.Error = fakeAWSError{}
+
+func writeFile(t *testing.T, root, rel, contents string) {
+	t.Helper()
+	path := filepath.Join(root, rel)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testUpload(t *testing.T, backend Backend, ignore []string) *S3Upload {
+	t.Helper()
+	source := t.TempDir()
+	cfg := &Config{}
+	cfg.S3.Source = source
+	cfg.S3.Ignore = ignore
+
+	return &S3Upload{Config: cfg, Backend: backend, SourcePath: source}
+}
+
+func TestIsUploadableFile(t *testing.T) {
+	cases := []struct {
+		name   string
+		ignore []string
+		path   string
+		want   bool
+	}{
+		{"no rules", nil, "index.html", true},
+		{"exact match ignored", []string{"index.html"}, "index.html", false},
+		{"glob match ignored", []string{"*.log"}, "debug.log", false},
+		{"glob does not match", []string{"*.log"}, "index.html", true},
+		{"nested glob ignored", []string{"**/*.tmp"}, "a/b/c.tmp", false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := testUpload(t, newFakeBackend(), tc.ignore)
+			got, err := s.isUploadableFile(tc.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tc.want {
+				t.Errorf("isUploadableFile(%q) = %v, want %v", tc.path, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestSourceFiles(t *testing.T) {
+	s := testUpload(t, newFakeBackend(), []string{"*.log"})
+
+	writeFile(t, s.SourcePath, "index.html", "hi")
+	writeFile(t, s.SourcePath, "static/app.js", "js")
+	writeFile(t, s.SourcePath, "debug.log", "noisy")
+	if err := os.MkdirAll(filepath.Join(s.SourcePath, "empty"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := s.sourceFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := map[string]bool{}
+	for _, f := range files {
+		got[f] = true
+	}
+
+	for _, want := range []string{"index.html", filepath.Join("static", "app.js")} {
+		if !got[want] {
+			t.Errorf("expected sourceFiles() to include %q, got %v", want, files)
+		}
+	}
+	if got["debug.log"] {
+		t.Errorf("expected sourceFiles() to skip ignored debug.log, got %v", files)
+	}
+}
+
+func TestUploadRetriesOnAWSError(t *testing.T) {
+	backend := newFakeBackend()
+	backend.failErr = fakeAWSError{}
+	backend.failKeysN["/one.txt"] = 1 // fail once, then succeed
+
+	s := testUpload(t, backend, nil)
+	writeFile(t, s.SourcePath, "one.txt", "hello")
+
+	num, err := s.Upload(1, false)
+	if err != nil {
+		t.Fatalf("Upload() error = %v", err)
+	}
+	if num != 1 {
+		t.Errorf("Upload() num = %d, want 1", num)
+	}
+	if len(backend.puts) != 1 {
+		t.Errorf("expected one successful put after retry, got %v", backend.puts)
+	}
+}
+
+func TestPutVerifiedRetriesOnETagMismatch(t *testing.T) {
+	backend := newFakeBackend()
+	backend.badEtagN["/one.txt"] = 2 // two corrupted responses, then the real one
+
+	s := testUpload(t, backend, nil)
+	writeFile(t, s.SourcePath, "one.txt", "hello")
+
+	file, err := os.Open(filepath.Join(s.SourcePath, "one.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	if err := s.putVerified(file, "one.txt", "/one.txt", PutOptions{}); err != nil {
+		t.Fatalf("putVerified() error = %v", err)
+	}
+	if len(backend.puts) != 3 {
+		t.Errorf("expected 3 attempts (2 mismatched + 1 matching), got %d", len(backend.puts))
+	}
+}
+
+func TestPutVerifiedFailsAfterExhaustingRetries(t *testing.T) {
+	backend := newFakeBackend()
+	backend.badEtagN["/one.txt"] = 1000 // never matches
+
+	s := testUpload(t, backend, nil)
+	s.Config.S3.VerifyRetries = 2
+	writeFile(t, s.SourcePath, "one.txt", "hello")
+
+	file, err := os.Open(filepath.Join(s.SourcePath, "one.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	if err := s.putVerified(file, "one.txt", "/one.txt", PutOptions{}); err == nil {
+		t.Fatal("expected putVerified() to fail after exhausting VerifyRetries")
+	}
+	if len(backend.puts) != 2 {
+		t.Errorf("expected exactly VerifyRetries=2 attempts, got %d", len(backend.puts))
+	}
+}
+
+func TestUploadAggregatesErrorsInsteadOfPanicking(t *testing.T) {
+	backend := newFakeBackend()
+	backend.failErr = fmt.Errorf("permanent failure")
+	backend.failKeysN["/broken.txt"] = 1000 // never succeeds, and isn't an awserr.Error
+
+	s := testUpload(t, backend, nil)
+	writeFile(t, s.SourcePath, "broken.txt", "hello")
+
+	if _, err := s.Upload(1, false); err == nil {
+		t.Fatal("expected Upload() to return an error instead of panicking")
+	}
+}
+
+func TestUploadParallelFanOut(t *testing.T) {
+	backend := newFakeBackend()
+	s := testUpload(t, backend, nil)
+
+	for i := 0; i < 20; i++ {
+		writeFile(t, s.SourcePath, fmt.Sprintf("file-%02d.txt", i), "data")
+	}
+
+	num, err := s.Upload(4, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if num != 20 {
+		t.Errorf("Upload() num = %d, want 20", num)
+	}
+	if len(backend.puts) != 20 {
+		t.Errorf("expected 20 puts, got %d", len(backend.puts))
+	}
+}
+
+func TestUploadDryRun(t *testing.T) {
+	backend := newFakeBackend()
+	s := testUpload(t, backend, nil)
+	writeFile(t, s.SourcePath, "index.html", "hi")
+
+	num, err := s.Upload(1, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if num != 0 {
+		t.Errorf("Upload(dryrun) num = %d, want 0", num)
+	}
+	if len(backend.puts) != 0 {
+		t.Errorf("expected dry run to make no puts, got %v", backend.puts)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// uploadState tracks multipart uploads that were started but not yet
+// completed, so an interrupted s3up run can find and clean them up (or
+// report on them) the next time it runs instead of leaving them orphaned
+// in the bucket. s3Backend shares one uploadState across the parallel
+// upload workers in S3Upload.Upload and the Handlers.Unmarshal callback that
+// records a new UploadId as soon as S3 hands it back, so every access
+// goes through mu.
+type uploadState struct {
+	mu      sync.Mutex
+	Uploads map[string]string `json:"uploads"` // dest key -> UploadId
+}
+
+func stateFilePath(sourcePath string) string {
+	return filepath.Join(sourcePath, ".s3up-state.json")
+}
+
+func loadUploadState(sourcePath string) (*uploadState, error) {
+	st := &uploadState{Uploads: map[string]string{}}
+
+	data, err := os.ReadFile(stateFilePath(sourcePath))
+	if os.IsNotExist(err) {
+		return st, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, st); err != nil {
+		return nil, err
+	}
+	if st.Uploads == nil {
+		st.Uploads = map[string]string{}
+	}
+	return st, nil
+}
+
+func (st *uploadState) save(sourcePath string) error {
+	st.mu.Lock()
+	data, err := json.Marshal(st)
+	st.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(stateFilePath(sourcePath), data, 0644)
+}
+
+func (st *uploadState) set(key, uploadID string) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.Uploads[key] = uploadID
+}
+
+func (st *uploadState) clear(key string) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	delete(st.Uploads, key)
+}
+
+// pending returns a snapshot of the tracked key -> UploadId pairs, safe
+// to range over without holding mu.
+func (st *uploadState) pending() map[string]string {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	snapshot := make(map[string]string, len(st.Uploads))
+	for k, v := range st.Uploads {
+		snapshot[k] = v
+	}
+	return snapshot
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+// localETag computes the ETag s3up expects a backend to report for an
+// up-to-date copy of path: a plain MD5 hex digest for files that upload
+// in a single part, or the multipart construction
+// md5(concat(md5(part)...))-N for anything that would be split into
+// parts by the S3 backend. Comparing against this lets --sync skip files
+// that are already up to date without re-uploading them.
+func (s *S3Upload) localETag(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	partSize := s.Config.S3.PartSize
+	if partSize <= 0 {
+		partSize = s3manager.DefaultUploadPartSize
+	}
+
+	if info.Size() <= partSize {
+		h := md5.New()
+		if _, err := io.Copy(h, file); err != nil {
+			return "", err
+		}
+		return hex.EncodeToString(h.Sum(nil)), nil
+	}
+
+	var digests []byte
+	numParts := 0
+	buf := make([]byte, partSize)
+	for {
+		n, err := io.ReadFull(file, buf)
+		if n > 0 {
+			sum := md5.Sum(buf[:n])
+			digests = append(digests, sum[:]...)
+			numParts++
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+
+	sum := md5.Sum(digests)
+	return fmt.Sprintf("%s-%d", hex.EncodeToString(sum[:]), numParts), nil
+}
+
+// unchanged reports whether the local file at path already matches the
+// object at destPath, so uploadFile can skip it under --sync.
+func (s *S3Upload) unchanged(path, destPath string) (bool, error) {
+	remoteSum, ok, err := s.Backend.HeadObject(destPath)
+	if err != nil || !ok {
+		return false, err
+	}
+
+	localSum, err := s.localETag(path)
+	if err != nil {
+		return false, err
+	}
+
+	return localSum == remoteSum, nil
+}
+
+// deleteOrphaned removes remote objects under Config.S3.Prefix that have
+// no corresponding local file, mirroring `aws s3 sync --delete`.
+func (s *S3Upload) deleteOrphaned(localFiles []string) error {
+	local := make(map[string]bool, len(localFiles))
+	for _, f := range localFiles {
+		local[filepath.Join("/", s.Config.S3.Prefix, f)] = true
+	}
+
+	keys, err := s.Backend.ListObjects(destPrefix(s.Config))
+	if err != nil {
+		return err
+	}
+
+	var toDelete []string
+	for _, key := range keys {
+		if !local[key] {
+			toDelete = append(toDelete, key)
+		}
+	}
+	if len(toDelete) == 0 {
+		return nil
+	}
+
+	fmt.Printf("deleting %d remote object(s) no longer present locally ...\n", len(toDelete))
+	return s.Backend.DeleteObjects(toDelete)
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLocalETagSinglePart(t *testing.T) {
+	s := testUpload(t, newFakeBackend(), nil)
+	writeFile(t, s.SourcePath, "small.txt", "hello")
+
+	got, err := s.localETag(filepath.Join(s.SourcePath, "small.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sum := md5.Sum([]byte("hello"))
+	want := hex.EncodeToString(sum[:])
+	if got != want {
+		t.Errorf("localETag() = %q, want %q (plain MD5, file fits in one part)", got, want)
+	}
+}
+
+func TestLocalETagMultipart(t *testing.T) {
+	s := testUpload(t, newFakeBackend(), nil)
+	s.Config.S3.PartSize = 4
+	writeFile(t, s.SourcePath, "big.bin", "aaaabbbbcc") // 3 parts of 4, 4, 2 bytes
+
+	got, err := s.localETag(filepath.Join(s.SourcePath, "big.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// md5(concat(md5("aaaa"), md5("bbbb"), md5("cc")))-3, the multipart
+	// ETag construction S3 uses for a 3-part upload.
+	want := "cf6c8a60b0e393d7e70f2e7564027788-3"
+	if got != want {
+		t.Errorf("localETag() = %q, want %q", got, want)
+	}
+}
+
+func TestUnchanged(t *testing.T) {
+	backend := newFakeBackend()
+	s := testUpload(t, backend, nil)
+	writeFile(t, s.SourcePath, "index.html", "hi")
+
+	path := filepath.Join(s.SourcePath, "index.html")
+
+	same, err := s.unchanged(path, "/index.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if same {
+		t.Error("unchanged() = true before the object has ever been uploaded")
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if _, err := backend.PutObject("/index.html", file, PutOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	same, err = s.unchanged(path, "/index.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !same {
+		t.Error("unchanged() = false for a file that matches the uploaded object")
+	}
+
+	writeFile(t, s.SourcePath, "index.html", "changed")
+	same, err = s.unchanged(path, "/index.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if same {
+		t.Error("unchanged() = true after the local file changed")
+	}
+}
+
+func TestDeleteOrphanedRespectsConfiguredPrefix(t *testing.T) {
+	backend := newFakeBackend()
+	s := testUpload(t, backend, nil)
+	// Written the normal way: no leading slash. Real keys are always
+	// filepath.Join("/", Prefix, path), e.g. "/assets/keep.txt".
+	s.Config.S3.Prefix = "assets"
+
+	if _, err := backend.PutObject("/assets/keep.txt", bytes.NewReader([]byte("keep")), PutOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := backend.PutObject("/assets/orphan.txt", bytes.NewReader([]byte("orphan")), PutOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.deleteOrphaned([]string{"keep.txt"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := backend.objects["/assets/keep.txt"]; !ok {
+		t.Error("deleteOrphaned() removed a file that's still present locally")
+	}
+	if _, ok := backend.objects["/assets/orphan.txt"]; ok {
+		t.Error("deleteOrphaned() did not remove an object under the configured prefix with no local counterpart")
+	}
+}